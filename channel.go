@@ -1,26 +1,99 @@
 package pool
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
+// DefaultCloseTimeout is a reasonable drain timeout to pass to
+// CloseWithTimeout for callers that don't have a more specific deadline of
+// their own.
+var DefaultCloseTimeout = 30 * time.Second
+
+// Options configures a channelPool beyond the plain capacity/maxActive
+// settings taken by NewChannelPoolMaxActive. NewChannelPoolWithOptions takes
+// the capacity settings as fields here too, so a pool can be built from a
+// single Options value.
+type Options struct {
+	InitialCap int
+	MaxCap     int
+	MaxActive  int
+	Factory    Factory
+
+	// MaxIdleTime closes a pooled connection that has been sitting unused in
+	// the pool for longer than this. Zero disables the check.
+	MaxIdleTime time.Duration
+	// MaxLifetime closes a connection once it has existed for longer than
+	// this, regardless of how recently it was used. Zero disables the check.
+	MaxLifetime time.Duration
+	// HealthCheck, if set, is run on a pooled connection before it is handed
+	// out; a non-nil error discards it the same way an expired MaxIdleTime or
+	// MaxLifetime would.
+	HealthCheck func(net.Conn) error
+
+	// Metrics, if set, receives lifecycle events for this pool. Defaults to a
+	// no-op implementation.
+	Metrics Metrics
+}
+
+// idleConn is what actually sits in channelPool.conns: the raw connection
+// plus enough timestamps to decide whether it's still worth handing out.
+type idleConn struct {
+	conn       net.Conn
+	createdAt  time.Time
+	returnedAt time.Time
+}
+
 // channelPool implements the Pool interface based on buffered channels.
 type channelPool struct {
 	// storage for our net.Conn connections
 	mu    sync.Mutex
-	conns chan net.Conn
+	conns chan *idleConn
 
-	// active connections limiter
+	// active connection limiter. When isLimited is set, the total number of
+	// live connections (pooled or checked out) is capped at maxActive; callers
+	// that exceed it wait in a FIFO queue of waiters instead of a plain
+	// semaphore so that no single waiter is starved under contention.
 	isLimited bool
-	actives   chan struct{}
+	maxActive int
+	active    int
+	waiters   list.List // of *waiter, oldest at Front
+
+	// outstanding holds every conn currently checked out of the pool (i.e.
+	// handed to a caller via wrapConn and not yet returned through put or
+	// tryClose). CloseWithTimeout drains this set before forcibly closing
+	// whatever is left.
+	outstanding map[net.Conn]struct{}
+	drained     *sync.Cond
+
+	// lifecycle limits; see Options.
+	maxIdleTime time.Duration
+	maxLifetime time.Duration
+	healthCheck func(net.Conn) error
+	stopReap    chan struct{}
+
+	metrics Metrics
 
 	// net.Conn generator
 	factory Factory
 }
 
+// waiter is queued by acquireActive when the pool is saturated. ready is
+// closed once this waiter has been handed an active slot. conn is nil when
+// the slot was freed by a disconnect (releaseActive) and the waiter must
+// dial its own replacement; it is set when a still-live connection was
+// handed off directly (put or offerIdle), in which case the waiter must
+// reuse it instead of dialing.
+type waiter struct {
+	ready chan struct{}
+	conn  *idleConn
+}
+
 // Factory is a function to create new connections.
 type Factory func() (net.Conn, error)
 
@@ -31,29 +104,52 @@ type Factory func() (net.Conn, error)
 // available in the pool, a new connection will be created via the Factory()
 // method (unless maxActive > 0, i.e. there is a limit for active connections).
 func NewChannelPoolMaxActive(initialCap, maxCap int, maxActive int, factory Factory) (Pool, error) {
+	return NewChannelPoolMaxActiveWithOptions(initialCap, maxCap, maxActive, factory, Options{})
+}
+
+// NewChannelPoolMaxActiveWithOptions behaves like NewChannelPoolMaxActive but
+// additionally applies the idle-connection lifecycle limits and Metrics in
+// opts.
+func NewChannelPoolMaxActiveWithOptions(initialCap, maxCap int, maxActive int, factory Factory, opts Options) (Pool, error) {
 	if initialCap < 0 || maxCap <= 0 || maxActive < 0 || initialCap > maxCap ||
 		(maxActive > 0 && maxActive < maxCap) {
 		return nil, errors.New("invalid capacity settings")
 	}
 
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
 	c := &channelPool{
-		conns:   make(chan net.Conn, maxCap),
-		factory: factory,
+		conns:       make(chan *idleConn, maxCap),
+		factory:     factory,
+		outstanding: make(map[net.Conn]struct{}),
+		maxIdleTime: opts.MaxIdleTime,
+		maxLifetime: opts.MaxLifetime,
+		healthCheck: opts.HealthCheck,
+		metrics:     metrics,
 	}
+	c.drained = sync.NewCond(&c.mu)
 	if maxActive > 0 {
 		c.isLimited = true
-		c.actives = make(chan struct{}, maxActive)
+		c.maxActive = maxActive
 	}
 
 	// create initial connections, if something goes wrong,
 	// just close the pool error out.
 	for i := 0; i < initialCap; i++ {
-		conn, err := c.tryOpen()
+		conn, createdAt, _, err := c.tryOpen(context.Background())
 		if err != nil {
 			c.Close()
 			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
 		}
-		c.conns <- conn
+		c.conns <- &idleConn{conn: conn, createdAt: createdAt, returnedAt: time.Now()}
+	}
+
+	if c.maxIdleTime > 0 || c.maxLifetime > 0 || c.healthCheck != nil {
+		c.stopReap = make(chan struct{})
+		go c.reapLoop(c.stopReap)
 	}
 
 	return c, nil
@@ -63,120 +159,523 @@ func NewChannelPool(initialCap, maxCap int, factory Factory) (Pool, error) {
 	return NewChannelPoolMaxActive(initialCap, maxCap, 0, factory)
 }
 
-func (c *channelPool) tryOpen() (net.Conn, error) {
-	// this will block if active connections are limited.
-	if c.isLimited {
-		c.actives <- struct{}{}
+// NewChannelPoolWithOptions builds a pool entirely from opts, including the
+// capacity settings that the other constructors take as positional args.
+func NewChannelPoolWithOptions(opts Options) (Pool, error) {
+	return NewChannelPoolMaxActiveWithOptions(opts.InitialCap, opts.MaxCap, opts.MaxActive, opts.Factory, opts)
+}
+
+// acquireActive reserves one of maxActive slots, blocking until one is free
+// or ctx is done. Callers that don't care about the active limit (isLimited
+// is false) return immediately. The returned idleConn is non-nil when the
+// slot came with a still-live connection attached (handed off by put or
+// offerIdle instead of being pushed through c.conns); the caller must reuse it rather
+// than dialing a new one.
+func (c *channelPool) acquireActive(ctx context.Context) (*idleConn, error) {
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if !c.isLimited {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	if c.active < c.maxActive {
+		c.active++
+		c.mu.Unlock()
+		c.reportActive()
+		return nil, nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	elem := c.waiters.PushBack(w)
+	c.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		c.mu.Lock()
+		closed := c.conns == nil
+		idle := w.conn
+		c.mu.Unlock()
+		if closed {
+			if idle != nil {
+				idle.conn.Close()
+				c.metrics.OnClose("shutdown")
+			}
+			return nil, ErrClosed
+		}
+		return idle, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		select {
+		case <-w.ready:
+			// Slot (or conn) was handed to us right as we gave up on it;
+			// don't leak it.
+			idle := w.conn
+			closed := c.conns == nil
+			c.mu.Unlock()
+			switch {
+			case idle == nil:
+				c.releaseActive()
+			case closed:
+				// Pool is shutting down, not full; just close it, same as
+				// the plain <-w.ready branch above does.
+				idle.conn.Close()
+				c.metrics.OnClose("shutdown")
+			case !c.offerIdle(idle):
+				c.metrics.OnClose("full")
+				c.tryClose(idle.conn)
+			}
+		default:
+			c.waiters.Remove(elem)
+			c.mu.Unlock()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquireActive is the non-blocking counterpart used by TryGet: it
+// reserves a slot if one is free and returns ErrConnLimit otherwise.
+func (c *channelPool) tryAcquireActive() error {
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if !c.isLimited {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.active >= c.maxActive {
+		c.mu.Unlock()
+		return ErrConnLimit
+	}
+	c.active++
+	c.mu.Unlock()
+	c.reportActive()
+	return nil
+}
+
+// releaseActive frees an active slot whose connection was just closed (no
+// idleConn to hand off, so the waiter that receives it must dial its own).
+// If a waiter is queued it is handed the slot directly (FIFO) instead of
+// decrementing the counter, keeping the transfer fair and avoiding a wakeup
+// race against new callers. close(w.ready) happens while c.mu is still held
+// so it's atomic with the ctx.Done check in acquireActive: closing a channel
+// never blocks, so there's no benefit to unlocking first, and doing so would
+// leave a window where a canceled waiter observes neither the close nor the
+// queue entry it was removed from.
+func (c *channelPool) releaseActive() {
+	c.mu.Lock()
+	if !c.isLimited {
+		c.mu.Unlock()
+		return
+	}
+	if front := c.waiters.Front(); front != nil {
+		c.waiters.Remove(front)
+		w := front.Value.(*waiter)
+		close(w.ready)
+		c.mu.Unlock()
+		return
+	}
+	c.active--
+	c.mu.Unlock()
+	c.reportActive()
+}
+
+// offerIdle returns a still-live connection to the pool that isn't tied to
+// an outstanding checkout: a connection acquireActive is putting back after
+// its waiter gave up, or one reapExpired pulled out and found not stale. If
+// a waiter is queued for an active slot, the connection is handed to it
+// directly instead of going through c.conns, so a waiter blocked dialing
+// never misses a connection that became available in the meantime. (put has
+// its own inline version of this handoff, since it additionally needs to
+// clear the outstanding entry atomically with it.) Otherwise idle is pushed
+// onto c.conns like before. Reports false, without closing idle itself, if
+// the pool is closed or the channel has no room — the caller is then
+// responsible for disposing of it the same way a full channel would have
+// required.
+func (c *channelPool) offerIdle(idle *idleConn) bool {
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return false
+	}
+	if front := c.waiters.Front(); front != nil {
+		c.waiters.Remove(front)
+		w := front.Value.(*waiter)
+		w.conn = idle
+		close(w.ready)
+		c.mu.Unlock()
+		return true
+	}
+	select {
+	case c.conns <- idle:
+		c.mu.Unlock()
+		return true
+	default:
+		c.mu.Unlock()
+		return false
+	}
+}
+
+// reportActive pushes the current active count to metrics, if it implements
+// activeGauge.
+func (c *channelPool) reportActive() {
+	ag, ok := c.metrics.(activeGauge)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	n := c.active
+	c.mu.Unlock()
+	ag.SetActive(n)
+}
+
+// tryOpen reserves an active slot and returns a connection for it, along
+// with the connection's createdAt and whether it was reused rather than
+// freshly dialed. If the slot came with a connection already handed off by
+// put or offerIdle, that connection is reused (reused == true) and nothing is
+// dialed; otherwise a new connection is created via the factory.
+func (c *channelPool) tryOpen(ctx context.Context) (conn net.Conn, createdAt time.Time, reused bool, err error) {
+	idle, err := c.acquireActive(ctx)
+	if err != nil {
+		return nil, time.Time{}, false, err
 	}
-	conn, err := c.factory()
+	if idle != nil {
+		return idle.conn, idle.createdAt, true, nil
+	}
+	start := time.Now()
+	conn, err = c.factory()
+	c.metrics.OnDial(err, time.Since(start))
 	if err != nil {
-		c.tryClose(conn)
+		c.releaseActive()
+		return nil, time.Time{}, false, err
 	}
-	return conn, err
+	return conn, time.Now(), false, nil
 }
 
 func (c *channelPool) tryClose(conn net.Conn) error {
-	// update active connection limit.
-	if c.isLimited {
-		<-c.actives
-	}
+	c.releaseActive()
 	if conn != nil {
+		c.mu.Lock()
+		delete(c.outstanding, conn)
+		c.drained.Broadcast()
+		c.mu.Unlock()
 		return conn.Close()
 	}
 	return nil
 }
 
-func (c *channelPool) getConns() chan net.Conn {
+func (c *channelPool) getConns() chan *idleConn {
 	c.mu.Lock()
 	conns := c.conns
 	c.mu.Unlock()
 	return conns
 }
 
-func (c *channelPool) getActives() chan struct{} {
-	c.mu.Lock()
-	actives := c.actives
-	c.mu.Unlock()
-	return actives
+// isStale reports whether idle has aged past MaxIdleTime/MaxLifetime or
+// fails HealthCheck, in which case it should be discarded instead of handed
+// out to a caller.
+func (c *channelPool) isStale(idle *idleConn) bool {
+	now := time.Now()
+	if c.maxIdleTime > 0 && now.Sub(idle.returnedAt) > c.maxIdleTime {
+		return true
+	}
+	if c.maxLifetime > 0 && now.Sub(idle.createdAt) > c.maxLifetime {
+		return true
+	}
+	if c.healthCheck != nil && c.healthCheck(idle.conn) != nil {
+		return true
+	}
+	return false
 }
 
 // Get implements the Pool interfaces Get() method. If there is no new
 // connection available in the pool, a new connection will be created via the
 // Factory() method.
 func (c *channelPool) Get() (net.Conn, error) {
-	conns := c.getConns()
-	if conns == nil {
-		return nil, ErrClosed
-	}
+	return c.GetContext(context.Background())
+}
 
-	// wrap our connections with out custom net.Conn implementation (wrapConn
-	// method) that puts the connection back to the pool if it's closed.
-	select {
-	case conn := <-conns:
-		if conn == nil {
+// GetContext implements the Pool interface's GetContext() method.
+func (c *channelPool) GetContext(ctx context.Context) (net.Conn, error) {
+	start := time.Now()
+	for {
+		conns := c.getConns()
+		if conns == nil {
 			return nil, ErrClosed
 		}
 
-		return c.wrapConn(conn), nil
-	default:
-		conn, err := c.tryOpen()
-		if err != nil {
-			return nil, err
+		// wrap our connections with out custom net.Conn implementation (wrapConn
+		// method) that puts the connection back to the pool if it's closed.
+		select {
+		case idle, ok := <-conns:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if c.isStale(idle) {
+				c.metrics.OnEvict()
+				c.tryClose(idle.conn)
+				continue
+			}
+			c.metrics.OnGet(time.Since(start))
+			return c.wrapConn(idle.conn, idle.createdAt), nil
+		default:
+			conn, createdAt, reused, err := c.tryOpen(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if reused {
+				// tryOpen handed back a connection that was sitting in the
+				// pool rather than dialing a fresh one (see offerIdle); run
+				// it through the same staleness check a plain channel read
+				// would have gotten. A freshly dialed connection skips this,
+				// same as the factory path below always has.
+				idle := &idleConn{conn: conn, createdAt: createdAt, returnedAt: time.Now()}
+				if c.isStale(idle) {
+					c.metrics.OnEvict()
+					c.tryClose(idle.conn)
+					continue
+				}
+			}
+
+			c.metrics.OnGet(time.Since(start))
+			return c.wrapConn(conn, createdAt), nil
+		}
+	}
+}
+
+// TryGet implements the Pool interface's TryGet() method.
+func (c *channelPool) TryGet() (net.Conn, error) {
+	start := time.Now()
+	for {
+		conns := c.getConns()
+		if conns == nil {
+			return nil, ErrClosed
 		}
 
-		return c.wrapConn(conn), nil
+		select {
+		case idle, ok := <-conns:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if c.isStale(idle) {
+				c.metrics.OnEvict()
+				c.tryClose(idle.conn)
+				continue
+			}
+			c.metrics.OnGet(time.Since(start))
+			return c.wrapConn(idle.conn, idle.createdAt), nil
+		default:
+			if err := c.tryAcquireActive(); err != nil {
+				return nil, err
+			}
+			dialStart := time.Now()
+			conn, err := c.factory()
+			c.metrics.OnDial(err, time.Since(dialStart))
+			if err != nil {
+				c.releaseActive()
+				return nil, err
+			}
+			c.metrics.OnGet(time.Since(start))
+			return c.wrapConn(conn, time.Now()), nil
+		}
 	}
 }
 
 // put puts the connection back to the pool. If the pool is full or closed,
 // conn is simply closed. A nil conn will be rejected.
-func (c *channelPool) put(conn net.Conn) error {
+func (c *channelPool) put(conn net.Conn, createdAt time.Time) error {
 	if conn == nil {
 		return errors.New("connection is nil. rejecting")
 	}
+	c.metrics.OnPut()
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.conns == nil {
-		// pool is closed, close passed connection
+		// pool is closed, close passed connection. One that CloseWithTimeout
+		// already force-closed was reported there and is not reported again.
+		_, wasOutstanding := c.outstanding[conn]
+		delete(c.outstanding, conn)
+		c.drained.Broadcast()
+		c.mu.Unlock()
+		if wasOutstanding {
+			c.metrics.OnClose("shutdown")
+		}
 		return conn.Close()
 	}
 
+	idle := &idleConn{conn: conn, createdAt: createdAt, returnedAt: time.Now()}
+
+	// Hand the connection straight to a caller already queued in
+	// acquireActive, if there is one, instead of leaving it sitting in
+	// c.conns unseen: that caller is blocked on w.ready, not on c.conns, so
+	// a plain channel send here would never wake it even though a
+	// connection just became available. This must stay in the same
+	// critical section as the outstanding-map delete below, or
+	// CloseWithTimeout could see conn as still outstanding and forcibly
+	// close it right after it's been handed to its new owner.
+	if front := c.waiters.Front(); front != nil {
+		c.waiters.Remove(front)
+		w := front.Value.(*waiter)
+		w.conn = idle
+		delete(c.outstanding, conn)
+		c.drained.Broadcast()
+		close(w.ready)
+		c.mu.Unlock()
+		return nil
+	}
+
 	// put the resource back into the pool. If the pool is full, this will
 	// block and the default case will be executed.
 	select {
-	case c.conns <- conn:
+	case c.conns <- idle:
+		delete(c.outstanding, conn)
+		c.drained.Broadcast()
+		c.mu.Unlock()
 		return nil
 	default:
-		// pool is full, close passed connection
+		// pool is full, close passed connection. Unlock first: tryClose
+		// releases an active slot and clears the outstanding entry, both of
+		// which take c.mu themselves.
+		c.mu.Unlock()
+		c.metrics.OnClose("full")
 		return c.tryClose(conn)
 	}
 }
 
+// Close closes the pool and all its connections immediately, including ones
+// still checked out by callers. Use CloseWithTimeout to give outstanding
+// connections a chance to be returned first.
 func (c *channelPool) Close() {
+	c.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout stops the pool from issuing new connections (subsequent
+// Get/GetContext/TryGet calls return ErrClosed) and waits up to d for
+// connections currently checked out to be returned via PoolConn.Close()
+// before forcibly closing whatever is still outstanding. It returns an error
+// naming how many connections were forcibly terminated, or nil if every
+// outstanding connection was returned before the deadline.
+func (c *channelPool) CloseWithTimeout(d time.Duration) error {
 	c.mu.Lock()
 	conns := c.conns
-	actives := c.actives
 	c.conns = nil
-	c.actives = nil
 	c.factory = nil
+	if c.stopReap != nil {
+		close(c.stopReap)
+		c.stopReap = nil
+	}
+
+	// wake up every queued waiter; each will see conns == nil and report
+	// ErrClosed instead of being handed a slot.
+	for e := c.waiters.Front(); e != nil; e = e.Next() {
+		close(e.Value.(*waiter).ready)
+	}
+	c.waiters.Init()
+
+	deadline := time.Now().Add(d)
+	timer := time.AfterFunc(d, func() {
+		c.mu.Lock()
+		c.drained.Broadcast()
+		c.mu.Unlock()
+	})
+	for len(c.outstanding) > 0 && time.Now().Before(deadline) {
+		c.drained.Wait()
+	}
+	timer.Stop()
+
+	forced := len(c.outstanding)
+	for conn := range c.outstanding {
+		conn.Close()
+	}
+	c.outstanding = make(map[net.Conn]struct{})
 	c.mu.Unlock()
 
+	for i := 0; i < forced; i++ {
+		c.metrics.OnClose("shutdown-forced")
+	}
+
+	if conns != nil {
+		close(conns)
+		for idle := range conns {
+			idle.conn.Close()
+			c.metrics.OnClose("shutdown")
+		}
+	}
+
+	if forced > 0 {
+		return fmt.Errorf("pool: forcibly closed %d outstanding connection(s) after %s", forced, d)
+	}
+	return nil
+}
+
+// reapLoop periodically scans the pool for idle connections that have
+// expired under MaxIdleTime/MaxLifetime (or now fail HealthCheck) and evicts
+// them, so long-lived peers don't keep handing out half-dead connections.
+// stop is passed in (rather than read off c.stopReap) because CloseWithTimeout
+// nils out c.stopReap under c.mu, and reapLoop must not read that field
+// unsynchronized.
+func (c *channelPool) reapLoop(stop <-chan struct{}) {
+	interval := c.maxIdleTime
+	if c.maxLifetime > 0 && (interval <= 0 || c.maxLifetime < interval) {
+		interval = c.maxLifetime
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.reapExpired()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reapExpired drains up to one pass over the pool's current contents,
+// closing anything stale and putting the rest back.
+func (c *channelPool) reapExpired() {
+	conns := c.getConns()
 	if conns == nil {
 		return
 	}
 
-	close(conns)
-	for conn := range conns {
-		conn.Close()
-	}
-	if c.isLimited {
-		close(actives)
-		for _ = range actives {
+	for n := len(conns); n > 0; n-- {
+		select {
+		case idle := <-conns:
+			if c.isStale(idle) {
+				c.metrics.OnEvict()
+				c.tryClose(idle.conn)
+				continue
+			}
+			// offerIdle (not a raw send on conns) so a caller already
+			// parked in acquireActive's wait queue for a slot is handed
+			// this connection directly instead of it sitting unseen in the
+			// channel.
+			if !c.offerIdle(idle) {
+				// Pool shrank or closed concurrently; don't block the reaper.
+				c.metrics.OnClose("full")
+				c.tryClose(idle.conn)
+			}
+		default:
+			return
 		}
 	}
 }
 
-func (c *channelPool) Len() int        { return len(c.getConns()) }
-func (c *channelPool) LenActives() int { return len(c.getActives()) }
+func (c *channelPool) Len() int { return len(c.getConns()) }
+func (c *channelPool) LenActives() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
@@ -0,0 +1,206 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts a throwaway TCP listener for the tests in this file
+// and returns a Factory that dials it, cleaned up via t.Cleanup.
+func startEchoServer(t *testing.T) Factory {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 256)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+
+	addr := l.Addr().String()
+	return func() (net.Conn, error) { return net.Dial("tcp", addr) }
+}
+
+// TestChannelPoolMaxActive_PutWakesQueuedGet reproduces a deadlock: on a
+// fully saturated pool (active == maxActive == maxCap), a caller blocked in
+// Get must be woken by a plain PoolConn.Close() from another goroutine, not
+// only by a connection actually failing and being torn down via tryClose.
+func TestChannelPoolMaxActive_PutWakesQueuedGet(t *testing.T) {
+	dial := startEchoServer(t)
+	p, err := NewChannelPoolMaxActive(0, 1, 1, dial)
+	if err != nil {
+		t.Fatalf("NewChannelPoolMaxActive: %s", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		conn2, err := p.Get()
+		if err == nil {
+			conn2.Close()
+		}
+		done <- err
+	}()
+
+	// Give the second Get time to actually park in the wait queue before the
+	// first connection is returned.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("second Get: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second Get never returned: put() didn't wake the queued waiter")
+	}
+}
+
+// TestChannelPoolMaxActive_CanceledWaiterDoesNotLeakSlot checks that a waiter
+// whose context times out while it is queued on a saturated pool gives up its
+// place without leaking a slot: once the held connection is returned, a new
+// GetContext still gets a connection.
+func TestChannelPoolMaxActive_CanceledWaiterDoesNotLeakSlot(t *testing.T) {
+	dial := startEchoServer(t)
+	p, err := NewChannelPoolMaxActive(0, 1, 1, dial)
+	if err != nil {
+		t.Fatalf("NewChannelPoolMaxActive: %s", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err == nil {
+		t.Fatal("expected GetContext to time out on a saturated pool")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel2()
+	conn3, err := p.GetContext(ctx2)
+	if err != nil {
+		t.Fatalf("slot appears leaked after a canceled waiter: %s", err)
+	}
+	conn3.Close()
+}
+
+// TestChannelPool_CloseWithTimeoutDrainsBeforeDeadline checks that
+// CloseWithTimeout waits for an outstanding connection to be returned via
+// PoolConn.Close() and reports nil once every connection drained back in,
+// instead of always forcing a deadline wait.
+func TestChannelPool_CloseWithTimeoutDrainsBeforeDeadline(t *testing.T) {
+	dial := startEchoServer(t)
+	p, err := NewChannelPool(0, 1, dial)
+	if err != nil {
+		t.Fatalf("NewChannelPool: %s", err)
+	}
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+
+	cp := p.(*channelPool)
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- cp.CloseWithTimeout(time.Second)
+	}()
+
+	// Give CloseWithTimeout a moment to start waiting before the connection
+	// is returned.
+	time.Sleep(50 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	select {
+	case err := <-closeErr:
+		if err != nil {
+			t.Fatalf("CloseWithTimeout: want nil after draining within the deadline, got %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CloseWithTimeout never returned after the outstanding connection was returned")
+	}
+}
+
+// TestChannelPool_CloseWithTimeoutForcesAfterDeadline checks that
+// CloseWithTimeout forcibly closes a connection that's never returned and
+// names the count in the returned error.
+func TestChannelPool_CloseWithTimeoutForcesAfterDeadline(t *testing.T) {
+	dial := startEchoServer(t)
+	p, err := NewChannelPool(0, 1, dial)
+	if err != nil {
+		t.Fatalf("NewChannelPool: %s", err)
+	}
+
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	// conn is deliberately never returned/closed.
+
+	err = p.(*channelPool).CloseWithTimeout(50 * time.Millisecond)
+	if err == nil {
+		t.Fatal("expected CloseWithTimeout to report an error for the still-outstanding connection")
+	}
+	want := "pool: forcibly closed 1 outstanding connection(s) after 50ms"
+	if err.Error() != want {
+		t.Fatalf("CloseWithTimeout error = %q, want %q", err.Error(), want)
+	}
+}
+
+// TestChannelPool_CloseIsIdempotent checks that a second Close() call is a
+// harmless no-op rather than blocking or panicking on already-nil state.
+func TestChannelPool_CloseIsIdempotent(t *testing.T) {
+	dial := startEchoServer(t)
+	p, err := NewChannelPool(0, 1, dial)
+	if err != nil {
+		t.Fatalf("NewChannelPool: %s", err)
+	}
+
+	p.Close()
+
+	done := make(chan struct{})
+	go func() {
+		p.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second Close() call did not return")
+	}
+}
@@ -3,15 +3,17 @@ package pool
 import (
 	"net"
 	"sync"
+	"time"
 )
 
 // PoolConn is a wrapper around net.Conn to modify the the behavior of
 // net.Conn's Close() method.
 type PoolConn struct {
 	net.Conn
-	mu       sync.RWMutex
-	c        *channelPool
-	unusable bool
+	mu        sync.RWMutex
+	c         *channelPool
+	unusable  bool
+	createdAt time.Time
 }
 
 func (p *PoolConn) Read(b []byte) (int, error) {
@@ -36,9 +38,10 @@ func (p *PoolConn) Close() error {
 	defer p.mu.RUnlock()
 
 	if p.unusable {
+		p.c.metrics.OnClose("unusable")
 		return p.c.tryClose(p.Conn)
 	}
-	return p.c.put(p.Conn)
+	return p.c.put(p.Conn, p.createdAt)
 }
 
 // MarkUnusable() marks the connection not usable any more, to let the pool close it instead of returning it to pool.
@@ -46,11 +49,18 @@ func (p *PoolConn) MarkUnusable() {
 	p.mu.Lock()
 	p.unusable = true
 	p.mu.Unlock()
+	p.c.metrics.OnMarkUnusable()
 }
 
-// newConn wraps a standard net.Conn to a poolConn net.Conn.
-func (c *channelPool) wrapConn(conn net.Conn) net.Conn {
-	p := &PoolConn{c: c}
+// wrapConn wraps a standard net.Conn to a poolConn net.Conn. createdAt is
+// carried along so MaxLifetime can be enforced even while the conn sits idle
+// in the pool between checkouts.
+func (c *channelPool) wrapConn(conn net.Conn, createdAt time.Time) net.Conn {
+	c.mu.Lock()
+	c.outstanding[conn] = struct{}{}
+	c.mu.Unlock()
+
+	p := &PoolConn{c: c, createdAt: createdAt}
 	p.Conn = conn
 	return p
 }
@@ -0,0 +1,126 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Manager owns one Pool per remote address, creating it lazily on first use.
+// It lets clients that talk to many peers (clustered systems, service
+// meshes) share a single pool registry instead of hand-rolling a
+// map[string]pool.Pool with their own locking.
+type Manager struct {
+	mu    sync.Mutex
+	pools map[string]Pool
+
+	initialCap, maxCap, maxActive int
+	newFactory                    func(addr string) Factory
+
+	// localAddr and localDial let callers short-circuit connections destined
+	// for the local node, bypassing the network entirely, the same way
+	// clustered systems route a request to themselves in-process.
+	localAddr string
+	localDial func() (net.Conn, error)
+}
+
+// NewManager returns a Manager that lazily creates a channel pool per
+// destination address, each one sized the same way NewChannelPoolMaxActive
+// is. newFactory builds the connection Factory for a given address.
+func NewManager(initialCap, maxCap, maxActive int, newFactory func(addr string) Factory) *Manager {
+	return &Manager{
+		pools:      make(map[string]Pool),
+		initialCap: initialCap,
+		maxCap:     maxCap,
+		maxActive:  maxActive,
+		newFactory: newFactory,
+	}
+}
+
+// SetLocalAddr configures addr as the local node's address. Subsequent Get
+// and GetContext calls for addr call dial directly instead of going through
+// a pool, so in-process requests never touch the network.
+func (m *Manager) SetLocalAddr(addr string, dial func() (net.Conn, error)) {
+	m.mu.Lock()
+	m.localAddr = addr
+	m.localDial = dial
+	m.mu.Unlock()
+}
+
+// Get returns a connection to addr, creating and filling a pool for it on
+// first use.
+func (m *Manager) Get(addr string) (net.Conn, error) {
+	return m.GetContext(context.Background(), addr)
+}
+
+// GetContext behaves like Get but honors ctx cancellation/deadline the same
+// way channelPool.GetContext does.
+func (m *Manager) GetContext(ctx context.Context, addr string) (net.Conn, error) {
+	m.mu.Lock()
+	isLocal := m.localDial != nil && addr == m.localAddr
+	dial := m.localDial
+	m.mu.Unlock()
+
+	if isLocal {
+		return dial()
+	}
+
+	p, err := m.poolFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetContext(ctx)
+}
+
+// poolFor returns the pool for addr, lazily creating it via newFactory on
+// first use.
+func (m *Manager) poolFor(addr string) (Pool, error) {
+	m.mu.Lock()
+	p, ok := m.pools[addr]
+	m.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	p, err := NewChannelPoolMaxActive(m.initialCap, m.maxCap, m.maxActive, m.newFactory(addr))
+	if err != nil {
+		return nil, fmt.Errorf("pool: failed to create pool for %s: %s", addr, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.pools[addr]; ok {
+		// Another goroutine raced us and created the pool first; use that
+		// one and throw ours away.
+		p.Close()
+		return existing, nil
+	}
+	m.pools[addr] = p
+	return p, nil
+}
+
+// CloseAddr closes and forgets the pool for addr, if one exists. A later Get
+// for addr creates a fresh pool.
+func (m *Manager) CloseAddr(addr string) {
+	m.mu.Lock()
+	p, ok := m.pools[addr]
+	delete(m.pools, addr)
+	m.mu.Unlock()
+
+	if ok {
+		p.Close()
+	}
+}
+
+// Close closes every pool the Manager has created so far.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	pools := m.pools
+	m.pools = make(map[string]Pool)
+	m.mu.Unlock()
+
+	for _, p := range pools {
+		p.Close()
+	}
+}
@@ -0,0 +1,116 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeConn is a minimal net.Conn that does no I/O, just enough to satisfy
+// Factory/Pool plumbing in tests that never actually read or write.
+type fakeConn struct {
+	net.Conn
+	onClose func()
+}
+
+func (f *fakeConn) Close() error {
+	if f.onClose != nil {
+		f.onClose()
+	}
+	return nil
+}
+
+// TestManager_ConcurrentGetSharesPool checks that concurrent Gets for the
+// same not-yet-seen address converge on a single shared pool, and that the
+// pool(s) built by the race losers in poolFor get closed rather than leaked.
+func TestManager_ConcurrentGetSharesPool(t *testing.T) {
+	const addr = "10.0.0.1:7000"
+	const racers = 8
+
+	var dials, closes int32
+	newFactory := func(addr string) Factory {
+		return func() (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return &fakeConn{onClose: func() { atomic.AddInt32(&closes, 1) }}, nil
+		}
+	}
+
+	// initialCap: 1 so every pool built by poolFor (including the ones built
+	// by race losers before they discover they lost) eagerly dials one
+	// connection, making dials/closes observable from outside the Manager.
+	m := NewManager(1, 1, 0, newFactory)
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	conns := make([]net.Conn, racers)
+	errs := make([]error, racers)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = m.GetContext(context.Background(), addr)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Get #%d: %s", i, err)
+		}
+		conns[i].Close()
+	}
+
+	m.mu.Lock()
+	n := len(m.pools)
+	m.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("len(m.pools) = %d, want 1: concurrent Gets did not converge on a single shared pool", n)
+	}
+
+	if got := atomic.LoadInt32(&dials); got < 2 {
+		t.Fatalf("dials = %d, want at least 2 (one per pool build plus one per Get that found no idle connection)", got)
+	}
+	// Every dialed connection except the one left idle in the winning pool
+	// should have been closed: each race-loser pool closes its one
+	// eagerly-dialed idle connection, and the Gets' extra connections are
+	// closed when returned to the winner's full pool.
+	if got, want := atomic.LoadInt32(&closes), atomic.LoadInt32(&dials)-1; got < want {
+		t.Fatalf("closes = %d, want at least %d: a race-loser pool was leaked instead of closed", got, want)
+	}
+}
+
+// TestManager_SetLocalAddrBypassesPool checks that once SetLocalAddr is
+// configured for an address, Get dials directly through it and never creates
+// or touches a pool for that address.
+func TestManager_SetLocalAddrBypassesPool(t *testing.T) {
+	const addr = "127.0.0.1:9"
+
+	newFactory := func(addr string) Factory {
+		return func() (net.Conn, error) {
+			return nil, errors.New("pooled factory must not be called for a local address")
+		}
+	}
+	m := NewManager(0, 1, 0, newFactory)
+	defer m.Close()
+
+	want := &fakeConn{}
+	m.SetLocalAddr(addr, func() (net.Conn, error) { return want, nil })
+
+	got, err := m.Get(addr)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if got != net.Conn(want) {
+		t.Fatalf("Get returned %v, want the local dial's connection %v", got, want)
+	}
+
+	m.mu.Lock()
+	n := len(m.pools)
+	m.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("len(m.pools) = %d, want 0: SetLocalAddr should bypass pooling entirely", n)
+	}
+}
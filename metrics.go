@@ -0,0 +1,134 @@
+package pool
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives lifecycle events from a channelPool so callers can
+// operationalize it in production instead of relying solely on Len() and
+// LenActives().
+type Metrics interface {
+	// OnGet is called every time Get/GetContext/TryGet successfully returns a
+	// connection, with the time the caller spent waiting for it.
+	OnGet(waitedFor time.Duration)
+	// OnPut is called every time a checked-out connection is returned via
+	// PoolConn.Close().
+	OnPut()
+	// OnDial is called after every factory() call, successful or not.
+	OnDial(err error, took time.Duration)
+	// OnClose is called whenever a connection is actually closed (as opposed
+	// to being returned to the pool), with a short reason such as "full",
+	// "unusable" or "shutdown". Connections discarded as stale are reported
+	// via OnEvict instead.
+	OnClose(reason string)
+	// OnMarkUnusable is called from PoolConn.MarkUnusable.
+	OnMarkUnusable()
+	// OnEvict is called when the idle-connection reaper (or a lazy check on
+	// Get) discards a connection for being stale rather than unusable.
+	OnEvict()
+}
+
+// noopMetrics is the zero-cost default used when Options.Metrics is nil.
+type noopMetrics struct{}
+
+func (noopMetrics) OnGet(time.Duration)         {}
+func (noopMetrics) OnPut()                      {}
+func (noopMetrics) OnDial(error, time.Duration) {}
+func (noopMetrics) OnClose(string)              {}
+func (noopMetrics) OnMarkUnusable()             {}
+func (noopMetrics) OnEvict()                    {}
+
+// activeGauge is an optional extension a Metrics implementation can satisfy
+// to receive live active-connection-count updates. Metrics implementations
+// that don't care about a gauge (most custom ones) simply don't implement it.
+type activeGauge interface {
+	SetActive(n int)
+}
+
+// waitBuckets are the upper bounds (inclusive) of the GetWaitHistogram
+// buckets, in ascending order. A wait lands in the first bucket whose bound
+// it does not exceed; waits past the last bound fall in "+Inf".
+var waitBuckets = []struct {
+	label string
+	bound time.Duration
+}{
+	{"1ms", time.Millisecond},
+	{"5ms", 5 * time.Millisecond},
+	{"10ms", 10 * time.Millisecond},
+	{"50ms", 50 * time.Millisecond},
+	{"100ms", 100 * time.Millisecond},
+	{"500ms", 500 * time.Millisecond},
+	{"1s", time.Second},
+}
+
+// waitBucket returns the GetWaitHistogram label d falls into.
+func waitBucket(d time.Duration) string {
+	for _, b := range waitBuckets {
+		if d <= b.bound {
+			return b.label
+		}
+	}
+	return "+Inf"
+}
+
+// ExpvarMetrics is a built-in Metrics implementation that publishes pool
+// activity as expvar counters/gauges, suitable for scraping via /debug/vars.
+type ExpvarMetrics struct {
+	Gets             *expvar.Int
+	GetWaitNanos     *expvar.Int // cumulative; GetWaitNanos/Gets is the mean wait
+	GetWaitHistogram *expvar.Map // wait time bucketed by waitBucket, see waitBuckets
+	Puts             *expvar.Int
+	DialAttempts     *expvar.Int
+	DialFailures     *expvar.Int
+	DialNanos        *expvar.Int
+	Closes           *expvar.Map // keyed by reason
+	MarkedUnusable   *expvar.Int
+	Evictions        *expvar.Int
+	Active           *expvar.Int
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics and publishes its fields under
+// "<name>.<field>" via expvar.Publish, which panics if name is already in
+// use -- callers should pick one name per pool instance.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{
+		Gets:             expvar.NewInt(name + ".gets"),
+		GetWaitNanos:     expvar.NewInt(name + ".get_wait_ns"),
+		GetWaitHistogram: expvar.NewMap(name + ".get_wait_histogram"),
+		Puts:             expvar.NewInt(name + ".puts"),
+		DialAttempts:     expvar.NewInt(name + ".dial_attempts"),
+		DialFailures:     expvar.NewInt(name + ".dial_failures"),
+		DialNanos:        expvar.NewInt(name + ".dial_ns"),
+		Closes:           expvar.NewMap(name + ".closes"),
+		MarkedUnusable:   expvar.NewInt(name + ".marked_unusable"),
+		Evictions:        expvar.NewInt(name + ".evictions"),
+		Active:           expvar.NewInt(name + ".active"),
+	}
+}
+
+func (m *ExpvarMetrics) OnGet(waitedFor time.Duration) {
+	m.Gets.Add(1)
+	m.GetWaitNanos.Add(waitedFor.Nanoseconds())
+	m.GetWaitHistogram.Add(waitBucket(waitedFor), 1)
+}
+
+func (m *ExpvarMetrics) OnPut() { m.Puts.Add(1) }
+
+func (m *ExpvarMetrics) OnDial(err error, took time.Duration) {
+	m.DialAttempts.Add(1)
+	m.DialNanos.Add(took.Nanoseconds())
+	if err != nil {
+		m.DialFailures.Add(1)
+	}
+}
+
+func (m *ExpvarMetrics) OnClose(reason string) { m.Closes.Add(reason, 1) }
+
+func (m *ExpvarMetrics) OnMarkUnusable() { m.MarkedUnusable.Add(1) }
+
+func (m *ExpvarMetrics) OnEvict() { m.Evictions.Add(1) }
+
+func (m *ExpvarMetrics) SetActive(n int) { m.Active.Set(int64(n)) }
+
+var _ Metrics = (*ExpvarMetrics)(nil)
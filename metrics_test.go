@@ -0,0 +1,170 @@
+package pool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics is a test-only Metrics (and activeGauge) implementation
+// that counts calls instead of publishing to expvar, so assertions don't
+// depend on expvar's process-wide namespace.
+type recordingMetrics struct {
+	mu        sync.Mutex
+	gets      int
+	dials     int
+	evictions int
+	closes    map[string]int
+	active    int
+}
+
+func (m *recordingMetrics) OnGet(time.Duration) {
+	m.mu.Lock()
+	m.gets++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) OnPut() {}
+
+func (m *recordingMetrics) OnDial(error, time.Duration) {
+	m.mu.Lock()
+	m.dials++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) OnClose(reason string) {
+	m.mu.Lock()
+	if m.closes == nil {
+		m.closes = make(map[string]int)
+	}
+	m.closes[reason]++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) OnMarkUnusable() {}
+
+func (m *recordingMetrics) OnEvict() {
+	m.mu.Lock()
+	m.evictions++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) SetActive(n int) {
+	m.mu.Lock()
+	m.active = n
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) snapshot() (gets, dials, evictions int, closes map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	closes = make(map[string]int, len(m.closes))
+	for k, v := range m.closes {
+		closes[k] = v
+	}
+	return m.gets, m.dials, m.evictions, closes
+}
+
+// waitFor polls check until it returns true or the deadline passes.
+func waitFor(t *testing.T, timeout time.Duration, check func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if check() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return check()
+}
+
+// TestMetrics_GetDialAndCloseFire checks that a Get/Put cycle followed by
+// Close() actually drives the Metrics hooks end-to-end, rather than just
+// being wired up and never invoked.
+func TestMetrics_GetDialAndCloseFire(t *testing.T) {
+	dial := startEchoServer(t)
+	rec := &recordingMetrics{}
+
+	p, err := NewChannelPoolMaxActiveWithOptions(0, 1, 1, dial, Options{Metrics: rec})
+	if err != nil {
+		t.Fatalf("NewChannelPoolMaxActiveWithOptions: %s", err)
+	}
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	gets, dials, _, _ := rec.snapshot()
+	if gets != 1 {
+		t.Fatalf("OnGet: want 1 call after one Get, got %d", gets)
+	}
+	if dials != 1 {
+		t.Fatalf("OnDial: want 1 call after one fresh dial, got %d", dials)
+	}
+
+	// conn is back in the idle channel; Close() must close it and report it
+	// via OnClose rather than dropping it silently.
+	p.Close()
+	if _, _, _, closes := rec.snapshot(); closes["shutdown"] == 0 {
+		t.Fatalf("OnClose never fired with reason \"shutdown\" for the idle connection still in the pool, got %v", closes)
+	}
+}
+
+// TestMetrics_EvictFires checks that the idle reaper's eviction of a
+// connection past MaxIdleTime drives OnEvict, not just the lazy check
+// inside Get.
+func TestMetrics_EvictFires(t *testing.T) {
+	dial := startEchoServer(t)
+	rec := &recordingMetrics{}
+
+	p, err := NewChannelPoolMaxActiveWithOptions(0, 1, 1, dial, Options{
+		MaxIdleTime: 20 * time.Millisecond,
+		Metrics:     rec,
+	})
+	if err != nil {
+		t.Fatalf("NewChannelPoolMaxActiveWithOptions: %s", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	// Let the idle connection age past MaxIdleTime, then let the reaper run.
+	time.Sleep(30 * time.Millisecond)
+	if !waitFor(t, 2*time.Second, func() bool {
+		_, _, evictions, _ := rec.snapshot()
+		return evictions >= 1
+	}) {
+		t.Fatal("OnEvict never fired for a connection idle past MaxIdleTime")
+	}
+}
+
+// TestWaitBucket checks the histogram bucketing ExpvarMetrics.OnGet relies
+// on: a wait lands in the first bucket whose bound it doesn't exceed, and
+// anything past the last bound falls into the overflow bucket.
+func TestWaitBucket(t *testing.T) {
+	cases := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "1ms"},
+		{time.Millisecond, "1ms"},
+		{2 * time.Millisecond, "5ms"},
+		{time.Second, "1s"},
+		{5 * time.Second, "+Inf"},
+	}
+	for _, c := range cases {
+		if got := waitBucket(c.wait); got != c.want {
+			t.Errorf("waitBucket(%s) = %q, want %q", c.wait, got, c.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"net"
 )
@@ -11,6 +12,11 @@ var (
 	ErrClosed = errors.New("pool is closed")
 	// This is the error resulting if the active connection limit is reached.
 	ErrConnLimit = errors.New("connection limit reached")
+	// ErrTimeout is returned by GetContext when ctx's deadline passes while
+	// the caller is queued for an active slot, distinguishing a timeout from
+	// the hard ErrConnLimit returned by TryGet. A canceled ctx returns
+	// context.Canceled instead.
+	ErrTimeout = errors.New("timed out waiting for a connection")
 )
 
 // Pool interface describes a pool implementation. A pool should have maximum
@@ -23,6 +29,14 @@ type Pool interface {
 	// the limit is reached (use TryGet() to avoid blocking).
 	Get() (net.Conn, error)
 
+	// GetContext behaves like Get() but returns early if ctx is done before a
+	// connection becomes available. If the active connection limit is
+	// reached, the caller is queued in FIFO order behind other waiters until
+	// a connection is returned, a new slot opens up, or ctx.Done() fires, in
+	// which case ctx.Err() is returned, except that a passed deadline is
+	// reported as ErrTimeout rather than context.DeadlineExceeded.
+	GetContext(ctx context.Context) (net.Conn, error)
+
 	// Behaves like Get() but will return ErrConnLimit instead of blocking when
 	// the active connection limit is reached.
 	TryGet() (net.Conn, error)